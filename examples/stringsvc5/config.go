@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/sony/gobreaker"
+
+	"github.com/go-kit/kit/endpoint"
+	kitratelimit "github.com/go-kit/kit/ratelimit"
+)
+
+// breakerConfig是gobreaker.Settings里我们愿意暴露给运维调整的那部分子集。
+// 零值都有合理的含义：MaxRequests为0时gobreaker按1个半开探测请求处理，
+// FailureRatio/MinRequests都是0时ReadyToTrip留空，退回gobreaker自己的默认
+// 策略（连续失败超过5次，即第6次连续失败时跳闸）。
+type breakerConfig struct {
+	MaxRequests  uint32        `json:"max_requests"`
+	Interval     time.Duration `json:"interval"`
+	Timeout      time.Duration `json:"timeout"`
+	MinRequests  uint32        `json:"min_requests"`
+	FailureRatio float64       `json:"failure_ratio"`
+}
+
+// instanceLimits描述一个实例允许的请求速率，既可以用于erroring限流器的qps，
+// 也可以用于delaying限流器的token bucket（qps是填充速率，burst是桶容量）。
+type instanceLimits struct {
+	QPS   int `json:"qps"`
+	Burst int `json:"burst"`
+}
+
+// proxyConfig是--proxy-config指向的JSON文件的内容，控制proxyingMiddleware
+// 给每个下游实例用的熔断器和限流器。不传--proxy-config时使用zeroProxyConfig，
+// 它复现了本文件引入之前的硬编码行为：默认gobreaker设置、100qps的erroring限流。
+type proxyConfig struct {
+	Breaker       breakerConfig             `json:"breaker"`
+	LimiterMode   string                    `json:"limiter_mode"` // "erroring" (default) or "delaying"
+	DefaultLimits instanceLimits            `json:"default_limits"`
+	PerInstance   map[string]instanceLimits `json:"per_instance"`
+}
+
+func zeroProxyConfig() proxyConfig {
+	return proxyConfig{
+		LimiterMode:   "erroring",
+		DefaultLimits: instanceLimits{QPS: 100, Burst: 100},
+	}
+}
+
+// loadProxyConfig从path读取并解析一个proxyConfig；path为空时返回zeroProxyConfig()。
+func loadProxyConfig(path string) (proxyConfig, error) {
+	if path == "" {
+		return zeroProxyConfig(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return proxyConfig{}, err
+	}
+	defer f.Close()
+
+	cfg := zeroProxyConfig()
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return proxyConfig{}, err
+	}
+	return cfg, nil
+}
+
+// limitsFor返回instance对应的限流配置，没有单独配置时落回DefaultLimits。
+func (cfg proxyConfig) limitsFor(instance string) instanceLimits {
+	if l, ok := cfg.PerInstance[instance]; ok {
+		return l
+	}
+	return cfg.DefaultLimits
+}
+
+// breakerSettings把breakerConfig翻译成gobreaker.Settings，name和onStateChange
+// 由调用方（sd.Factory）按实例填入。
+func (cfg proxyConfig) breakerSettings(name string, onStateChange func(name string, from, to gobreaker.State)) gobreaker.Settings {
+	settings := gobreaker.Settings{
+		Name:          name,
+		MaxRequests:   cfg.Breaker.MaxRequests,
+		Interval:      cfg.Breaker.Interval,
+		Timeout:       cfg.Breaker.Timeout,
+		OnStateChange: onStateChange,
+	}
+	if cfg.Breaker.MinRequests > 0 || cfg.Breaker.FailureRatio > 0 {
+		settings.ReadyToTrip = func(counts gobreaker.Counts) bool {
+			if counts.Requests < cfg.Breaker.MinRequests {
+				return false
+			}
+			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+			return failureRatio >= cfg.Breaker.FailureRatio
+		}
+	}
+	return settings
+}
+
+// limiterFor按cfg.LimiterMode给instance构建一个限流endpoint.Middleware：
+// erroring模式超出qps直接返回错误，delaying模式则用golang.org/x/time/rate的
+// 令牌桶阻塞到有令牌为止，适合调用方能容忍排队等待的场景。两种模式共用同一个
+// *rate.Limiter：它的Wait(ctx) error方法本身就满足kitratelimit.NewDelayingLimiter
+// 要求的Waiter接口，不需要额外的适配层。
+func (cfg proxyConfig) limiterFor(instance string) endpoint.Middleware {
+	limits := cfg.limitsFor(instance)
+	if limits.QPS <= 0 {
+		limits = instanceLimits{QPS: 100, Burst: 100}
+	}
+	if limits.Burst <= 0 {
+		limits.Burst = limits.QPS
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(limits.QPS), limits.Burst)
+	switch cfg.LimiterMode {
+	case "delaying":
+		return kitratelimit.NewDelayingLimiter(limiter)
+	default:
+		return kitratelimit.NewErroringLimiter(limiter)
+	}
+}
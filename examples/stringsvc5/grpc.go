@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	ot "github.com/opentracing/opentracing-go"
+
+	"github.com/go-kit/kit/log"
+	kitot "github.com/go-kit/kit/tracing/opentracing"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+
+	"github.com/go-kit/kit/examples/stringsvc5/pb"
+)
+
+// grpcServer实现pb.StringServiceServer，把gRPC请求翻译成go-kit的endpoint调用。
+// Count是一元RPC，直接复用go-kit的grpctransport.Server；Uppercase是流式RPC，
+// go-kit的grpc transport本身只封装一元调用，所以这里对每个到达的分片手动调用一次
+// endpoint，再把结果写回流里。
+type grpcServer struct {
+	uppercase grpctransport.Handler
+	count     grpctransport.Handler
+
+	pb.UnimplementedStringServiceServer
+}
+
+// NewGRPCServer makes a set of endpoints available as a gRPC StringServiceServer.
+func NewGRPCServer(svc StringService, tracer ot.Tracer, logger log.Logger) pb.StringServiceServer {
+	return &grpcServer{
+		uppercase: grpctransport.NewServer(
+			TracingMiddleware(tracer, "Uppercase")(makeUppercaseEndpoint(svc)),
+			decodeGRPCUppercaseRequest,
+			encodeGRPCUppercaseResponse,
+			grpctransport.ServerBefore(kitot.GRPCToContext(tracer, "gRPC Uppercase", logger)),
+			grpctransport.ServerErrorLogger(logger),
+		),
+		count: grpctransport.NewServer(
+			TracingMiddleware(tracer, "Count")(makeCountEndpoint(svc)),
+			decodeGRPCCountRequest,
+			encodeGRPCCountResponse,
+			grpctransport.ServerBefore(kitot.GRPCToContext(tracer, "gRPC Count", logger)),
+			grpctransport.ServerErrorLogger(logger),
+		),
+	}
+}
+
+// Count是一元RPC，一次请求对应一次响应。
+func (g *grpcServer) Count(ctx context.Context, req *pb.CountRequest) (*pb.CountReply, error) {
+	_, rep, err := g.count.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.CountReply), nil
+}
+
+// Uppercase循环读取客户端发来的分片，逐个转成大写后流式写回，
+// 直到客户端关闭发送端（io.EOF）。
+func (g *grpcServer) Uppercase(stream pb.StringService_UppercaseServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, rep, err := g.uppercase.ServeGRPC(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(rep.(*pb.UppercaseReply)); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeGRPCUppercaseRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UppercaseRequest)
+	return uppercaseRequest{S: req.S}, nil
+}
+
+func encodeGRPCUppercaseResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(uppercaseResponse)
+	return &pb.UppercaseReply{V: resp.V, Err: resp.Err}, nil
+}
+
+func decodeGRPCCountRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CountRequest)
+	return countRequest{S: req.S}, nil
+}
+
+func encodeGRPCCountResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(countResponse)
+	return &pb.CountReply{V: int64(resp.V)}, nil
+}
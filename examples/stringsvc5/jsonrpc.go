@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	jsonrpctransport "github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// makeJSONRPCHandler把Uppercase和Count注册成一个JSON-RPC 2.0端点，挂在/rpc上。
+// go-kit的jsonrpctransport.Server只认识单个请求对象，不识别批量请求（一个JSON
+// 数组），而jsonrpcBatcher发出去的永远是数组，所以这里在它前面加一层batchHandler：
+// 请求体是数组就自己按ecm逐个分派再拼回数组响应，否则原样交给jsonrpctransport.Server。
+func makeJSONRPCHandler(svc StringService, logger log.Logger) http.Handler {
+	ecm := jsonrpctransport.EndpointCodecMap{
+		"Uppercase": jsonrpctransport.EndpointCodec{
+			Endpoint: makeUppercaseEndpoint(svc),
+			Decode:   decodeUppercaseJSONRPCRequest,
+			Encode:   encodeUppercaseJSONRPCResponse,
+		},
+		"Count": jsonrpctransport.EndpointCodec{
+			Endpoint: makeCountEndpoint(svc),
+			Decode:   decodeCountJSONRPCRequest,
+			Encode:   encodeCountJSONRPCResponse,
+		},
+	}
+	return &batchHandler{
+		ecm:    ecm,
+		single: jsonrpctransport.NewServer(ecm, jsonrpctransport.ServerErrorLogger(logger)),
+		logger: logger,
+	}
+}
+
+// batchHandler让/rpc既能处理单个JSON-RPC请求对象，也能处理一个请求数组。单个对象
+// 原样转给go-kit的jsonrpctransport.Server；数组则挨个按method在ecm里查到对应的
+// EndpointCodec，自己完成解码、调用、编码，再把结果按原顺序拼成一个数组响应，id
+// 用来让jsonrpcBatcher把每条响应配对回发起调用的那个goroutine。
+type batchHandler struct {
+	ecm    jsonrpctransport.EndpointCodecMap
+	single http.Handler
+	logger log.Logger
+}
+
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body.Close()
+
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.single.ServeHTTP(w, r)
+		return
+	}
+
+	var batch []rawRequest
+	if err := json.Unmarshal(trimmed, &batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responses := make([]rawResponse, len(batch))
+	for i, req := range batch {
+		responses[i] = h.dispatch(r.Context(), req)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(responses); err != nil {
+		h.logger.Log("err", err)
+	}
+}
+
+// dispatch跑完一个批量请求里的单个元素，把EndpointCodec出的任何错误都翻译成
+// JSON-RPC 2.0的错误响应，而不是让它中断整个批量请求。
+func (h *batchHandler) dispatch(ctx context.Context, req rawRequest) rawResponse {
+	codec, ok := h.ecm[req.Method]
+	if !ok {
+		return rawResponse{Jsonrpc: "2.0", ID: req.ID, Error: &rawError{
+			Code:    jsonrpctransport.MethodNotFoundError,
+			Message: fmt.Sprintf("method %s was not found", req.Method),
+		}}
+	}
+
+	request, err := codec.Decode(ctx, req.Params)
+	if err != nil {
+		return rawResponse{Jsonrpc: "2.0", ID: req.ID, Error: &rawError{Code: jsonrpctransport.ParseError, Message: err.Error()}}
+	}
+
+	response, err := codec.Endpoint(ctx, request)
+	if err != nil {
+		return rawResponse{Jsonrpc: "2.0", ID: req.ID, Error: &rawError{Code: jsonrpctransport.InternalError, Message: err.Error()}}
+	}
+
+	result, err := codec.Encode(ctx, response)
+	if err != nil {
+		return rawResponse{Jsonrpc: "2.0", ID: req.ID, Error: &rawError{Code: jsonrpctransport.InternalError, Message: err.Error()}}
+	}
+	return rawResponse{Jsonrpc: "2.0", ID: req.ID, Result: result}
+}
+
+func decodeUppercaseJSONRPCRequest(_ context.Context, msg json.RawMessage) (interface{}, error) {
+	var req uppercaseRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return nil, &jsonrpctransport.Error{Code: jsonrpctransport.ParseError, Message: err.Error()}
+	}
+	return req, nil
+}
+
+func encodeUppercaseJSONRPCResponse(_ context.Context, response interface{}) (json.RawMessage, error) {
+	return json.Marshal(response.(uppercaseResponse))
+}
+
+func decodeCountJSONRPCRequest(_ context.Context, msg json.RawMessage) (interface{}, error) {
+	var req countRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return nil, &jsonrpctransport.Error{Code: jsonrpctransport.ParseError, Message: err.Error()}
+	}
+	return req, nil
+}
+
+func encodeCountJSONRPCResponse(_ context.Context, response interface{}) (json.RawMessage, error) {
+	return json.Marshal(response.(countResponse))
+}
+
+// jsonrpcBatchWindow是客户端把多个并发Uppercase调用攒成一个JSON-RPC批量请求
+// 等待的时间。攒批能省往返次数，但会给每次调用加上最多这么多的延迟，所以故意
+// 取得很短。
+const jsonrpcBatchWindow = 10 * time.Millisecond
+
+// rawRequest/rawResponse是JSON-RPC 2.0协议里请求/响应的线上格式，id用来把
+// 批量响应里的每一条结果对回发起调用的那个goroutine。
+type rawRequest struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      int             `json:"id"`
+}
+
+type rawResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rawError       `json:"error,omitempty"`
+	ID      int             `json:"id"`
+}
+
+type rawError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// jsonrpcCall是一通还没发出去的调用，在batcher的队列里等着跟其他调用拼成
+// 一个批量请求，result用来把响应送回调用它的goroutine。
+type jsonrpcCall struct {
+	params json.RawMessage
+	result chan rawResponse
+	err    chan error
+}
+
+// jsonrpcBatcher把同一个jsonrpcBatchWindow窗口内发往同一个instance的Uppercase
+// 调用攒成一个JSON-RPC批量请求（一个JSON数组），发一次HTTP POST，再按id把响应
+// 拆回去。每个makeUppercaseJSONRPCProxy维护自己的一个batcher。
+type jsonrpcBatcher struct {
+	url string
+
+	mu      sync.Mutex
+	pending []*jsonrpcCall
+	timer   *time.Timer
+}
+
+func newJSONRPCBatcher(instance string) (*jsonrpcBatcher, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = "/rpc"
+	}
+	return &jsonrpcBatcher{url: u.String()}, nil
+}
+
+func (b *jsonrpcBatcher) call(params json.RawMessage) (rawResponse, error) {
+	call := &jsonrpcCall{params: params, result: make(chan rawResponse, 1), err: make(chan error, 1)}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, call)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(jsonrpcBatchWindow, b.flush)
+	}
+	b.mu.Unlock()
+
+	select {
+	case resp := <-call.result:
+		return resp, nil
+	case err := <-call.err:
+		return rawResponse{}, err
+	}
+}
+
+func (b *jsonrpcBatcher) flush() {
+	b.mu.Lock()
+	calls := b.pending
+	b.pending = nil
+	b.timer = nil
+	b.mu.Unlock()
+
+	if len(calls) == 0 {
+		return
+	}
+
+	batch := make([]rawRequest, len(calls))
+	for i, c := range calls {
+		batch[i] = rawRequest{Jsonrpc: "2.0", Method: "Uppercase", Params: c.params, ID: i}
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(batch); err != nil {
+		failAll(calls, err)
+		return
+	}
+
+	resp, err := http.Post(b.url, "application/json", &buf)
+	if err != nil {
+		failAll(calls, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var responses []rawResponse
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		failAll(calls, err)
+		return
+	}
+
+	byID := make(map[int]rawResponse, len(responses))
+	for _, r := range responses {
+		byID[r.ID] = r
+	}
+	for i, c := range calls {
+		r, ok := byID[i]
+		if !ok {
+			c.err <- fmt.Errorf("jsonrpc batch: no response for request %d", i)
+			continue
+		}
+		c.result <- r
+	}
+}
+
+func failAll(calls []*jsonrpcCall, err error) {
+	for _, c := range calls {
+		c.err <- err
+	}
+}
+
+// makeUppercaseJSONRPCProxy是makeUppercaseProxy的JSON-RPC版本：每次调用把请求
+// 交给这个实例专属的jsonrpcBatcher，由它在jsonrpcBatchWindow内攒批发送。
+func makeUppercaseJSONRPCProxy(ctx context.Context, instance string) (endpoint.Endpoint, error) {
+	batcher, err := newJSONRPCBatcher(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	e := func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(uppercaseRequest)
+		params, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := batcher.call(params)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("jsonrpc error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+
+		var uresp uppercaseResponse
+		if err := json.Unmarshal(resp.Result, &uresp); err != nil {
+			return nil, err
+		}
+		return uresp, nil
+	}
+	return e, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+)
+
+// InstrumentingMiddleware给StringService的每个方法记录一个带{method, error}标签的
+// 调用计数和耗时直方图，另外给Count单独记一个结果值的直方图（字符串长度的分布）。
+func InstrumentingMiddleware(requestCount metrics.Counter, requestLatency metrics.Histogram, countResult metrics.Histogram) ServiceMiddleware {
+	return func(next StringService) StringService {
+		return instrumentingMiddleware{
+			requestCount:   requestCount,
+			requestLatency: requestLatency,
+			countResult:    countResult,
+			next:           next,
+		}
+	}
+}
+
+type instrumentingMiddleware struct {
+	requestCount   metrics.Counter
+	requestLatency metrics.Histogram
+	countResult    metrics.Histogram
+	next           StringService
+}
+
+func (mw instrumentingMiddleware) Uppercase(ctx context.Context, s string) (output string, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "uppercase", "error", fmt.Sprint(err != nil)}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	output, err = mw.next.Uppercase(ctx, s)
+	return
+}
+
+func (mw instrumentingMiddleware) Count(ctx context.Context, s string) (n int) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "count", "error", "false"}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+		mw.countResult.Observe(float64(n))
+	}(time.Now())
+
+	n = mw.next.Count(ctx, s)
+	return
+}
+
+// newServiceMetrics构建main里挂到InstrumentingMiddleware上的三个指标，
+// 全部挂在stringsvc命名空间下，Prometheus抓取时通过/metrics暴露。
+func newServiceMetrics() (requestCount metrics.Counter, requestLatency metrics.Histogram, countResult metrics.Histogram) {
+	requestCount = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "stringsvc",
+		Subsystem: "string_service",
+		Name:      "request_count",
+		Help:      "Number of requests received.",
+	}, []string{"method", "error"})
+
+	requestLatency = prometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "stringsvc",
+		Subsystem: "string_service",
+		Name:      "request_latency_seconds",
+		Help:      "Total duration of requests in seconds.",
+	}, []string{"method", "error"})
+
+	countResult = prometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "stringsvc",
+		Subsystem: "string_service",
+		Name:      "count_result",
+		Help:      "The result of each count method.",
+	}, []string{})
+
+	return requestCount, requestLatency, countResult
+}
+
+// newUpstreamMetrics构建代理到下游实例那一跳用的指标：每次重试循环的耗时，
+// 以及gobreaker熔断器跳闸（进入open状态）的次数，按实例打label。
+func newUpstreamMetrics() (upstreamLatency metrics.Histogram, breakerTrips metrics.Counter) {
+	upstreamLatency = prometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: "stringsvc",
+		Subsystem: "proxy",
+		Name:      "upstream_latency_seconds",
+		Help:      "Duration of the retrying, load-balanced call to an upstream instance.",
+	}, []string{"error"})
+
+	breakerTrips = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "stringsvc",
+		Subsystem: "proxy",
+		Name:      "breaker_trips_total",
+		Help:      "Number of times a circuit breaker to an upstream instance has opened.",
+	}, []string{"instance"})
+
+	return upstreamLatency, breakerTrips
+}
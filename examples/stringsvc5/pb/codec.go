@@ -0,0 +1,34 @@
+package pb
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// jsonCodec让UppercaseRequest/UppercaseReply/CountRequest/CountReply这几个手写的
+// message类型（见stringsvc.pb.go开头的说明）能在gRPC连接上实际收发。grpc-go默认
+// 用名为"proto"的编码器处理请求/响应体，而那个编码器要求消息实现proto.Message
+// （Reset/String/ProtoReflect），这几个结构体没有。这里注册一个同样叫"proto"的
+// 编码器，用encoding/json顶上，覆盖掉grpc-go自带的那个——RegisterCodec按名字
+// 覆盖，所以只要这个包被import就会生效，调用方不需要显式指定content-subtype。
+// 这不是真正的protobuf wire格式，只是在没有protoc的环境里让这些消息类型能正确
+// marshal/unmarshal的权宜之计；等用真·protoc生成的stub替换掉这几个文件时，这个
+// 编码器也应该一起删掉。
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "proto"
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
@@ -0,0 +1,64 @@
+// stringsvc.pb.go一模一样地对应了stringsvc.proto里的四个message，但不是protoc-gen-go
+// 生成的：这个沙箱里没有protoc，也拉不到protoc-gen-go（见stringsvc.proto旁边缺的那个
+// 生成脚本），所以这些结构体是手写的，只提供了字段和Getter。它们不满足
+// google.golang.org/protobuf要求的proto.Message接口（没有Reset/String/ProtoReflect，
+// 也没有file descriptor），grpc-go默认的"proto"编码器没法marshal/unmarshal它们——
+// 这一半由codec.go里注册的自定义编码器顶上，把"proto"这个content-subtype改成用
+// encoding/json编码这几个结构体，代价是这不是真正的protobuf wire格式。等这个环境
+// 能跑protoc时，应该用`protoc --go_out=. --go-grpc_out=. stringsvc.proto`重新生成
+// 这两个文件，然后把codec.go删掉。
+package pb
+
+// UppercaseRequest is one chunk of the string to be uppercased.
+type UppercaseRequest struct {
+	S string `protobuf:"bytes,1,opt,name=s,proto3" json:"s,omitempty"`
+}
+
+func (m *UppercaseRequest) GetS() string {
+	if m != nil {
+		return m.S
+	}
+	return ""
+}
+
+// UppercaseReply is one chunk of the uppercased response.
+type UppercaseReply struct {
+	V   string `protobuf:"bytes,1,opt,name=v,proto3" json:"v,omitempty"`
+	Err string `protobuf:"bytes,2,opt,name=err,proto3" json:"err,omitempty"`
+}
+
+func (m *UppercaseReply) GetV() string {
+	if m != nil {
+		return m.V
+	}
+	return ""
+}
+
+func (m *UppercaseReply) GetErr() string {
+	if m != nil {
+		return m.Err
+	}
+	return ""
+}
+
+type CountRequest struct {
+	S string `protobuf:"bytes,1,opt,name=s,proto3" json:"s,omitempty"`
+}
+
+func (m *CountRequest) GetS() string {
+	if m != nil {
+		return m.S
+	}
+	return ""
+}
+
+type CountReply struct {
+	V int64 `protobuf:"varint,1,opt,name=v,proto3" json:"v,omitempty"`
+}
+
+func (m *CountReply) GetV() int64 {
+	if m != nil {
+		return m.V
+	}
+	return 0
+}
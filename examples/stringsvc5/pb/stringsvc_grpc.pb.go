@@ -0,0 +1,152 @@
+// stringsvc_grpc.pb.go是protoc-gen-go-grpc本来会从stringsvc.proto生成的client/server
+// 代码的手写版本——这个环境没有protoc/protoc-gen-go-grpc（见stringsvc.pb.go开头的
+// 说明），没法真的跑生成器，所以照着go-kit其它example里生成代码的形状手抄了一份。
+// 等以后能跑`protoc --go-grpc_out=.`了，应该用真正生成的版本替换掉这个文件。
+package pb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StringServiceClient is the client API for StringService service.
+type StringServiceClient interface {
+	Uppercase(ctx context.Context, opts ...grpc.CallOption) (StringService_UppercaseClient, error)
+	Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountReply, error)
+}
+
+type stringServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewStringServiceClient 根据一个已经建立好的gRPC连接构造客户端。
+func NewStringServiceClient(cc *grpc.ClientConn) StringServiceClient {
+	return &stringServiceClient{cc}
+}
+
+func (c *stringServiceClient) Uppercase(ctx context.Context, opts ...grpc.CallOption) (StringService_UppercaseClient, error) {
+	stream, err := c.cc.NewStream(ctx, &StringService_ServiceDesc.Streams[0], "/pb.StringService/Uppercase", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &stringServiceUppercaseClient{stream}, nil
+}
+
+func (c *stringServiceClient) Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountReply, error) {
+	out := new(CountReply)
+	if err := c.cc.Invoke(ctx, "/pb.StringService/Count", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StringService_UppercaseClient is the stream handle returned by Uppercase.
+type StringService_UppercaseClient interface {
+	Send(*UppercaseRequest) error
+	Recv() (*UppercaseReply, error)
+	grpc.ClientStream
+}
+
+type stringServiceUppercaseClient struct {
+	grpc.ClientStream
+}
+
+func (x *stringServiceUppercaseClient) Send(m *UppercaseRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *stringServiceUppercaseClient) Recv() (*UppercaseReply, error) {
+	m := new(UppercaseReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StringServiceServer is the server API for StringService service.
+type StringServiceServer interface {
+	Uppercase(StringService_UppercaseServer) error
+	Count(context.Context, *CountRequest) (*CountReply, error)
+}
+
+// UnimplementedStringServiceServer must be embedded for forward compatibility.
+type UnimplementedStringServiceServer struct{}
+
+func (UnimplementedStringServiceServer) Uppercase(StringService_UppercaseServer) error {
+	return status.Errorf(codes.Unimplemented, "method Uppercase not implemented")
+}
+
+func (UnimplementedStringServiceServer) Count(context.Context, *CountRequest) (*CountReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+
+// StringService_UppercaseServer is the stream handle passed to the server's Uppercase method.
+type StringService_UppercaseServer interface {
+	Send(*UppercaseReply) error
+	Recv() (*UppercaseRequest, error)
+	grpc.ServerStream
+}
+
+type stringServiceUppercaseServer struct {
+	grpc.ServerStream
+}
+
+func (x *stringServiceUppercaseServer) Send(m *UppercaseReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *stringServiceUppercaseServer) Recv() (*UppercaseRequest, error) {
+	m := new(UppercaseRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _StringService_Uppercase_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StringServiceServer).Uppercase(&stringServiceUppercaseServer{stream})
+}
+
+func _StringService_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StringServiceServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/pb.StringService/Count"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StringServiceServer).Count(ctx, req.(*CountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RegisterStringServiceServer registers srv with s.
+func RegisterStringServiceServer(s grpc.ServiceRegistrar, srv StringServiceServer) {
+	s.RegisterService(&StringService_ServiceDesc, srv)
+}
+
+// StringService_ServiceDesc is the grpc.ServiceDesc for StringService.
+var StringService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.StringService",
+	HandlerType: (*StringServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Count",
+			Handler:    _StringService_Count_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Uppercase",
+			Handler:       _StringService_Uppercase_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "stringsvc.proto",
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	ot "github.com/opentracing/opentracing-go"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/lb"
+	kitot "github.com/go-kit/kit/tracing/opentracing"
+	httptransport "github.com/go-kit/kit/transport/http"
+
+	"github.com/go-kit/kit/examples/stringsvc5/pb"
+)
+
+// proxyingMiddleware把大写请求代理给sdURL描述的一组下游实例，sdURL的形式是
+// scheme://target（参见buildInstancer），scheme决定了实例列表从哪里来：
+// static的逗号分隔地址、consul、etcd或dnssrv。每个实例对应的端点由makeFactory
+// 按cfg（参见config.go）构建，包含健康检查、可配置的熔断器和限流器；
+// sd.NewEndpointer负责在Instancer上报实例增减时保持端点集合同步。
+// upstreamLatency和breakerTrips是给这一整条重试/负载均衡链路记的metrics。
+func proxyingMiddleware(ctx context.Context, proxyScheme string, sdURL string, cfg proxyConfig, tracer ot.Tracer, upstreamLatency metrics.Histogram, breakerTrips metrics.Counter, logger log.Logger) ServiceMiddleware {
+	// 如果sdURL为空，请不要代理。
+	if sdURL == "" {
+		logger.Log("proxy_to", "none")
+		return func(next StringService) StringService { return next }
+	}
+
+	// 为我们的客户设置一些参数。
+	const (
+		maxAttempts = 3                      // per request, before giving up
+		maxTime     = 250 * time.Millisecond // wallclock time, before giving up
+	)
+
+	instancer, err := buildInstancer(sdURL, logger)
+	if err != nil {
+		logger.Log("err", err)
+		return func(next StringService) StringService { return next }
+	}
+	logger.Log("proxy_scheme", proxyScheme, "sd", sdURL, "limiter_mode", cfg.LimiterMode)
+
+	endpointer := sd.NewEndpointer(instancer, makeFactory(ctx, proxyScheme, cfg, tracer, breakerTrips, logger), logger)
+
+	// 现在，在所有这些单个端点中构建一个单个重试的负载平衡端点。
+	balancer := lb.NewRoundRobin(endpointer)
+	retry := lb.Retry(maxAttempts, maxTime, balancer)
+	retry = instrumentUpstream(upstreamLatency)(retry)
+
+	// 最后，返回由proxymw实施的ServiceMiddleware。
+	return func(next StringService) StringService {
+		return proxymw{ctx, next, retry}
+	}
+}
+
+// instrumentUpstream记录整个重试/负载均衡端点（一次Uppercase代理调用，可能
+// 包含多次实际尝试）花费的时间，带上{error}标签。
+func instrumentUpstream(upstreamLatency metrics.Histogram) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				upstreamLatency.With("error", fmt.Sprint(err != nil)).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// proxymw实现StringService，将大写请求转发到提供的endpoint，并通过所有其他（即计数）请求通过下一个StringService。
+type proxymw struct {
+	ctx       context.Context
+	next      StringService     // Serve most requests via this service...
+	uppercase endpoint.Endpoint // ...except Uppercase, which gets served by this endpoint
+}
+
+func (mw proxymw) Count(ctx context.Context, s string) int {
+	return mw.next.Count(ctx, s)
+}
+
+func (mw proxymw) Uppercase(ctx context.Context, s string) (string, error) {
+	response, err := mw.uppercase(ctx, uppercaseRequest{S: s})
+	if err != nil {
+		return "", err
+	}
+
+	resp := response.(uppercaseResponse)
+	if resp.Err != "" {
+		return resp.V, errors.New(resp.Err)
+	}
+	return resp.V, nil
+}
+
+// makeUppercaseProxy构建一个通过HTTP调用远端Uppercase的端点，与stringsvc3中的版本相同，
+// 额外套了一层ContextToHTTP，把ctx里的span按tracer自己的HTTP头格式（zipkin是B3头）
+// 注入到出站请求，让下游能接上这条trace。
+// instance解析失败时返回错误而不是panic：这个函数是从sd.Factory里调用的，
+// panic会直接打掉整个server进程，而不只是拒绝这一个实例。
+func makeUppercaseProxy(ctx context.Context, instance string, tracer ot.Tracer) (endpoint.Endpoint, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = "/uppercase"
+	}
+	return httptransport.NewClient(
+		"GET",
+		u,
+		encodeRequest,
+		decodeUppercaseHTTPResponse,
+		httptransport.ClientBefore(kitot.ContextToHTTP(tracer, log.NewNopLogger())),
+	).Endpoint(), nil
+}
+
+// makeGRPCUppercaseProxy是makeUppercaseProxy的gRPC版本。Uppercase在.proto里是
+// 双向流式RPC，go-kit的grpctransport.Client只封装一元调用，所以这里直接使用生成
+// 的pb.StringServiceClient打开一个流，发送单个分片、读取对应的回复，再关闭发送端。
+// span的注入用kitot.ContextToGRPC手动做一次，效果和httptransport.ClientBefore等价。
+// 返回的io.Closer是底层的*grpc.ClientConn，调用方（makeFactory）要负责在端点被
+// sd.Endpointer回收时Close它，不然每个上报过的实例都会泄漏一个连接。
+func makeGRPCUppercaseProxy(ctx context.Context, instance string, tracer ot.Tracer) (endpoint.Endpoint, io.Closer, error) {
+	conn, err := grpc.NewClient(instance, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, err
+	}
+	client := pb.NewStringServiceClient(conn)
+
+	e := func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(uppercaseRequest)
+
+		md := metadata.MD{}
+		ctx = kitot.ContextToGRPC(tracer, log.NewNopLogger())(ctx, &md)
+		ctx = metadata.NewOutgoingContext(ctx, md)
+
+		stream, err := client.Uppercase(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&pb.UppercaseRequest{S: req.S}); err != nil {
+			return nil, err
+		}
+		if err := stream.CloseSend(); err != nil {
+			return nil, err
+		}
+		reply, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return uppercaseResponse{V: reply.V, Err: reply.Err}, nil
+	}
+	return e, conn, nil
+}
+
+func encodeRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(uppercaseRequest)
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(&buf)
+	return nil
+}
+
+func decodeUppercaseHTTPResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var response uppercaseResponse
+	if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// 按逗号拆分，去除两边空格
+func split(s string) []string {
+	a := strings.Split(s, ",")
+	for i := range a {
+		a[i] = strings.TrimSpace(a[i])
+	}
+	return a
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+
+	ot "github.com/opentracing/opentracing-go"
+
+	"github.com/sony/gobreaker"
+
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/consul"
+	"github.com/go-kit/kit/sd/dnssrv"
+	"github.com/go-kit/kit/sd/etcdv3"
+)
+
+// buildInstancer把--sd标志的值（scheme://target）翻译成对应后端的sd.Instancer。
+//
+//	static://a,b,c            固定的逗号分隔地址列表，不做服务发现
+//	consul://addr/service     从Consul的addr上查询service的健康实例
+//	etcd://addr/prefix        从etcd v3的addr上监听prefix下的key
+//	dnssrv://name             周期性解析name的DNS SRV记录
+func buildInstancer(sdURL string, logger log.Logger) (sd.Instancer, error) {
+	scheme, target, err := splitSD(sdURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "static":
+		return sd.NewFixedInstancer(split(target)), nil
+
+	case "consul":
+		addr, service := splitHostPath(target)
+		client, err := consulapi.NewClient(&consulapi.Config{Address: addr})
+		if err != nil {
+			return nil, err
+		}
+		// passingOnly=true：只把Consul健康检查通过的实例交给负载均衡器。
+		return consul.NewInstancer(consul.NewClient(client), logger, service, nil, true), nil
+
+	case "etcd":
+		addr, prefix := splitHostPath(target)
+		client, err := etcdv3.NewClient(context.Background(), []string{addr}, etcdv3.ClientOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return etcdv3.NewInstancer(client, prefix, logger)
+
+	case "dnssrv":
+		return dnssrv.NewInstancer(target, 30*time.Second, logger), nil
+
+	default:
+		return nil, fmt.Errorf("unknown sd scheme %q", scheme)
+	}
+}
+
+// livenessInterval是makeFactory给已经加入负载均衡池的实例安排的后台/health
+// 重新探活的周期。healthCheck只在Instancer第一次上报这个实例时跑一次，没有
+// 这个周期性探活的话，一个实例在运行中途挂掉不会被发现，proxymw会一直把请求
+// 转发给它，直到它自己的请求失败次数攒够gobreaker.Settings里配的阈值为止。
+const livenessInterval = 5 * time.Second
+
+// makeFactory返回一个sd.Factory。每当Instancer上报一个新实例时调用一次：
+// 先按proxyScheme做一次健康检查（见healthCheck），失败就直接返回错误，这样
+// sd.Endpointer永远不会把一个探活不通的实例放进负载均衡池；探活通过后再构建
+// 代理端点，套上按cfg配置出来的熔断器和限流器，最外层再套一个livenessGate，
+// 由它在后台按livenessInterval周期性重新探活，一旦探活失败就让端点直接返回
+// 错误而不再真的转发请求，逼lb.Retry换到池子里的其他实例上，直到它重新探活
+// 成功。熔断器每次跳闸（进入open状态）都会通过breakerTrips计数，并打一条
+// 日志，方便运维看到某个实例被摘除了。makeGRPCUppercaseProxy、makeUppercaseJSONRPCProxy、
+// makeUppercaseProxy都可能因为连接/解析失败而出错——都当普通error处理，不能让
+// 任何一个panic，不然加一个实例就能打掉整个server进程。
+func makeFactory(ctx context.Context, proxyScheme string, cfg proxyConfig, tracer ot.Tracer, breakerTrips metrics.Counter, logger log.Logger) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		if err := healthCheck(proxyScheme, instance); err != nil {
+			return nil, nil, err
+		}
+
+		var e endpoint.Endpoint
+		var closer io.Closer
+		var err error
+		switch proxyScheme {
+		case "grpc":
+			e, closer, err = makeGRPCUppercaseProxy(ctx, instance, tracer)
+		case "jsonrpc":
+			e, err = makeUppercaseJSONRPCProxy(ctx, instance)
+		default:
+			e, err = makeUppercaseProxy(ctx, instance, tracer)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		settings := cfg.breakerSettings(instance, func(name string, from, to gobreaker.State) {
+			logger.Log("breaker", name, "instance", instance, "from", from, "to", to)
+			if to == gobreaker.StateOpen {
+				breakerTrips.With("instance", instance).Add(1)
+			}
+		})
+		e = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(settings))(e)
+		e = cfg.limiterFor(instance)(e)
+
+		live := newLivenessGate(proxyScheme, instance, livenessInterval, logger)
+		e = live.wrap(e)
+		return e, closers{live, closer}, nil
+	}
+}
+
+// closers把多个io.Closer（例如gRPC连接和livenessGate的后台goroutine）合成一个，
+// 好塞进sd.Factory只留了一个返回值的io.Closer里；nil条目会被跳过，Close按顺序
+// 全部调用一遍，返回遇到的第一个错误。
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if c == nil {
+			continue
+		}
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// livenessGate是healthCheck的后台延续：newLivenessGate启动一个goroutine，每隔
+// interval对instance重新做一次healthCheck，把结果存成一个布尔位供wrap出来的
+// endpoint读取。Close停掉这个goroutine，在sd.Endpointer判定instance已经从
+// Instancer上消失、需要释放对应端点时调用。
+type livenessGate struct {
+	proxyScheme string
+	instance    string
+	logger      log.Logger
+
+	mu      sync.Mutex
+	healthy bool
+
+	done chan struct{}
+}
+
+func newLivenessGate(proxyScheme, instance string, interval time.Duration, logger log.Logger) *livenessGate {
+	g := &livenessGate{proxyScheme: proxyScheme, instance: instance, logger: logger, healthy: true, done: make(chan struct{})}
+	go g.run(interval)
+	return g
+}
+
+func (g *livenessGate) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := healthCheck(g.proxyScheme, g.instance)
+
+			g.mu.Lock()
+			changed := g.healthy != (err == nil)
+			g.healthy = err == nil
+			g.mu.Unlock()
+
+			if changed {
+				g.logger.Log("liveness", g.instance, "healthy", err == nil)
+			}
+		case <-g.done:
+			return
+		}
+	}
+}
+
+func (g *livenessGate) Close() error {
+	close(g.done)
+	return nil
+}
+
+// wrap让next在每次被调用前先看一眼后台探活的最新结果：不健康就直接返回错误，
+// 不再把请求转发给一个我们已经知道连/health都连不上的实例。
+func (g *livenessGate) wrap(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		g.mu.Lock()
+		healthy := g.healthy
+		g.mu.Unlock()
+
+		if !healthy {
+			return nil, fmt.Errorf("instance %s is failing liveness checks", g.instance)
+		}
+		return next(ctx, request)
+	}
+}
+
+// healthCheck按proxyScheme探活instance。http/jsonrpc走的是同一个进程里暴露
+// /health的那个HTTP server，GET一下/health就行；grpc实例的instance地址是gRPC
+// 监听地址，不会应答HTTP/1.1的GET，所以只做一次TCP连通性探测——比真的invoke一个
+// gRPC健康检查服务弱，但至少不会像直接拿HTTP GET打gRPC端口那样必然失败。
+func healthCheck(proxyScheme, instance string) error {
+	if proxyScheme == "grpc" {
+		return grpcHealthCheck(instance)
+	}
+	return httpHealthCheck(instance)
+}
+
+func grpcHealthCheck(instance string) error {
+	conn, err := net.DialTimeout("tcp", instance, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("instance %s failed health check: %w", instance, err)
+	}
+	return conn.Close()
+}
+
+func httpHealthCheck(instance string) error {
+	addr := instance
+	if !strings.HasPrefix(addr, "http") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	u.Path = "/health"
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("instance %s failed health check: %s", instance, resp.Status)
+	}
+	return nil
+}
+
+// splitSD把"scheme://target"拆成scheme和target两部分。
+func splitSD(sdURL string) (scheme, target string, err error) {
+	parts := strings.SplitN(sdURL, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("malformed --sd value %q, want scheme://target", sdURL)
+	}
+	return parts[0], parts[1], nil
+}
+
+// splitHostPath把"host:port/rest"拆成host:port和rest两部分，
+// 用于consul://addr/service和etcd://addr/prefix。
+func splitHostPath(target string) (host, rest string) {
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+
+	zipkinotbridge "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go"
+	zipkinhttpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+
+	otelbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/nats-io/nats.go"
+)
+
+// buildTracer根据--tracer的值构建一个opentracing.Tracer，连同一个在进程退出时
+// 应该被调用的io.Closer用来刷新/关闭底层导出器。noop什么都不做，适合本地调试。
+func buildTracer(name string) (ot.Tracer, io.Closer, error) {
+	switch name {
+	case "zipkin":
+		reporter := zipkinhttpreporter.NewReporter("http://localhost:9411/api/v2/spans")
+		endpoint, err := zipkin.NewEndpoint("stringsvc5", "")
+		if err != nil {
+			return nil, nil, err
+		}
+		native, err := zipkin.NewTracer(reporter, zipkin.WithLocalEndpoint(endpoint))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zipkinotbridge.Wrap(native), ioCloser(reporter.Close), nil
+
+	case "jaeger":
+		cfg := jaegercfg.Configuration{ServiceName: "stringsvc5"}
+		tracer, closer, err := cfg.NewTracer()
+		if err != nil {
+			return nil, nil, err
+		}
+		return tracer, closer, nil
+
+	case "otlp":
+		exporter, err := otlptrace.New(context.Background(), otlptracegrpc.NewClient())
+		if err != nil {
+			return nil, nil, err
+		}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+		bridge, _ := otelbridge.NewTracerPair(provider.Tracer("stringsvc5"))
+		return bridge, ioCloser(func() error { return provider.Shutdown(context.Background()) }), nil
+
+	case "noop", "":
+		return ot.NoopTracer{}, ioCloser(func() error { return nil }), nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown tracer %q", name)
+	}
+}
+
+type ioCloser func() error
+
+func (f ioCloser) Close() error { return f() }
+
+// TracingMiddleware把每一次endpoint调用包装成tracer下的一个span：如果context里
+// 已经有一个父span（通常是HTTP/NATS/gRPC transport层解码出来的），新span就是它的
+// 子span，从而让HTTP-in、NATS发布/订阅（经natsInjectSpan/natsExtractSpan）和被
+// 代理的HTTP/gRPC调用在tracer里连成一条线。
+func TracingMiddleware(tracer ot.Tracer, operationName string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			var span ot.Span
+			if parent := ot.SpanFromContext(ctx); parent != nil {
+				span = tracer.StartSpan(operationName, ot.ChildOf(parent.Context()))
+			} else {
+				span = tracer.StartSpan(operationName)
+			}
+			defer span.Finish()
+			ctx = ot.ContextWithSpan(ctx, span)
+
+			response, err := next(ctx, request)
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.LogKV("error", err.Error())
+			}
+			return response, err
+		}
+	}
+}
+
+// natsInjectSpan是natsExtractSpan的发布端一半：把ctx里当前的span（如果有）用
+// tracer.Inject/ot.TextMap写进msg的消息头，跟natsExtractSpan用tracer.Extract读的
+// 是同一种载体格式。makeUppercaseHTTPEndpoint在把HTTP请求发布到NATS之前调用它，
+// 这样订阅端的natsExtractSpan才能把两边接成一条trace，而不是总开一个新的根span。
+func natsInjectSpan(ctx context.Context, tracer ot.Tracer, msg *nats.Msg) {
+	span := ot.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	carrier := ot.TextMapCarrier{}
+	if err := tracer.Inject(span.Context(), ot.TextMap, carrier); err != nil {
+		return
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	for k, v := range carrier {
+		msg.Header.Set(k, v)
+	}
+}
+
+// natsExtractSpan在NATS订阅端把消息头里的span上下文接到一个新span上（如果消息
+// 带着的话，即natsInjectSpan写过的那种），让通过NATS发起的调用也能接进调用方的
+// trace；没有的话就开一个新的根span。
+func natsExtractSpan(ctx context.Context, tracer ot.Tracer, operationName string, msg *nats.Msg) context.Context {
+	carrier := ot.TextMapCarrier{}
+	for k := range msg.Header {
+		carrier[k] = msg.Header.Get(k)
+	}
+	spanCtx, err := tracer.Extract(ot.TextMap, carrier)
+	var span ot.Span
+	if err != nil {
+		span = tracer.StartSpan(operationName)
+	} else {
+		span = tracer.StartSpan(operationName, ot.ChildOf(spanCtx))
+	}
+	return ot.ContextWithSpan(ctx, span)
+}